@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	tf "github.com/tensorflow/tensorflow/tensorflow/go"
+)
+
+var (
+	batchMaxSize     = 16
+	batchConcurrency = 4
+
+	// batchURLClient fetches "urls" batch entries. Its dialer re-checks
+	// every resolved address against isDisallowedIP so a redirect or a
+	// DNS answer that changes between validateBatchURL and the actual
+	// connection can't be used to reach a loopback/private/link-local
+	// target (TOCTOU/DNS-rebinding SSRF).
+	batchURLClient = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: safeDialContext,
+		},
+	}
+)
+
+// safeDialContext resolves addr itself and refuses to connect if any
+// resolved address is loopback, private, link-local, or unspecified.
+func safeDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if isDisallowedIP(ip.IP) {
+			return nil, fmt.Errorf("refusing to connect to disallowed address %s", ip.IP)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// isDisallowedIP reports whether ip points at loopback, private, or
+// link-local address space, i.e. somewhere a batch URL fetch must never
+// reach.
+func isDisallowedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// validateBatchURL rejects anything but http/https schemes and hosts that
+// resolve to loopback/private/link-local addresses, so /recognize/batch
+// can't be used to probe the server's internal network.
+func validateBatchURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("URL %q uses unsupported scheme %q, only http/https are allowed", raw, parsed.Scheme)
+	}
+
+	host := parsed.Hostname()
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("resolving host %q: %w", host, err)
+	}
+	for _, ip := range ips {
+		if isDisallowedIP(ip) {
+			return fmt.Errorf("URL %q resolves to disallowed address %s", raw, ip)
+		}
+	}
+	return nil
+}
+
+// BatchResult is one input image's outcome within a /recognize/batch
+// response: its labels, or the error that prevented producing them, and
+// how long fetching + preprocessing took for that image alone. Inference
+// runs once for the whole batch and is reported in BatchResponse.InferenceMS.
+type BatchResult struct {
+	Source    string              `json:"source"`
+	Results   []RecognitionResult `json:"results,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	LatencyMS float64             `json:"latency_ms"`
+}
+
+// BatchResponse is the payload returned by /recognize/batch.
+type BatchResponse struct {
+	Results      []BatchResult `json:"results"`
+	PreprocessMS float64       `json:"preprocess_ms"`
+	InferenceMS  float64       `json:"inference_ms"`
+	TotalMS      float64       `json:"total_ms"`
+}
+
+// decodedImage is the outcome of preprocessing a single batch entry.
+type decodedImage struct {
+	source string
+	tensor *tf.Tensor
+	err    error
+	took   time.Duration
+}
+
+// batchSource is one input to a batch request, whether it came from a
+// multipart "image" part or a URL in the "urls" field: both just need to
+// produce raw image bytes under a label used in the response.
+type batchSource struct {
+	label string
+	fetch func() ([]byte, error)
+}
+
+// batchHandler decodes every "image" part of a multipart form, plus any
+// URL listed in a JSON array under the "urls" field, concurrently; stacks
+// the results into a single [N][H][W][3] tensor; and runs one session.Run
+// call instead of one per image.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Error parsing multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var urls []string
+	if raw := r.FormValue("urls"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &urls); err != nil {
+			http.Error(w, `Error parsing "urls" field as a JSON array of strings: `+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, u := range urls {
+			if err := validateBatchURL(u); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 && len(urls) == 0 {
+		http.Error(w, `No images provided: expected multipart "image" parts or a "urls" JSON array`, http.StatusBadRequest)
+		return
+	}
+	if len(files)+len(urls) > batchMaxSize {
+		http.Error(w, fmt.Sprintf("Batch of %d images exceeds the limit of %d", len(files)+len(urls), batchMaxSize), http.StatusBadRequest)
+		return
+	}
+
+	sources := make([]batchSource, 0, len(files)+len(urls))
+	for _, fh := range files {
+		sources = append(sources, fileSource(fh))
+	}
+	for _, u := range urls {
+		sources = append(sources, urlSource(u))
+	}
+
+	start := time.Now()
+	decoded := decodeBatch(sources)
+	preprocessElapsed := time.Since(start)
+
+	inferStart := time.Now()
+	response := runBatch(decoded)
+	response.PreprocessMS = preprocessElapsed.Seconds() * 1000
+	response.InferenceMS = time.Since(inferStart).Seconds() * 1000
+	response.TotalMS = time.Since(start).Seconds() * 1000
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// fileSource reads a multipart file part's bytes on demand.
+func fileSource(fh *multipart.FileHeader) batchSource {
+	return batchSource{
+		label: fh.Filename,
+		fetch: func() ([]byte, error) {
+			file, err := fh.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+			return ioutil.ReadAll(file)
+		},
+	}
+}
+
+// urlSource fetches a remote image's bytes on demand.
+func urlSource(u string) batchSource {
+	return batchSource{
+		label: u,
+		fetch: func() ([]byte, error) {
+			resp, err := batchURLClient.Get(u)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("fetching %s: %s", u, resp.Status)
+			}
+			return ioutil.ReadAll(resp.Body)
+		},
+	}
+}
+
+// decodeBatch preprocesses every source concurrently, bounded by
+// batchConcurrency, and returns results in the same order as sources.
+func decodeBatch(sources []batchSource) []decodedImage {
+	results := make([]decodedImage, len(sources))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, src := range sources {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, src batchSource) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			started := time.Now()
+			results[i] = decodeOne(src)
+			results[i].took = time.Since(started)
+		}(i, src)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// decodeOne fetches and preprocesses a single batch source.
+func decodeOne(src batchSource) decodedImage {
+	data, err := src.fetch()
+	if err != nil {
+		return decodedImage{source: src.label, err: err}
+	}
+
+	tensor, err := prepareInput(data)
+	if err != nil {
+		return decodedImage{source: src.label, err: err}
+	}
+
+	return decodedImage{source: src.label, tensor: tensor}
+}
+
+// runBatch stacks the successfully decoded tensors into one [N][H][W][3]
+// tensor and runs a single session.Run call against it.
+func runBatch(decoded []decodedImage) BatchResponse {
+	response := BatchResponse{Results: make([]BatchResult, len(decoded))}
+
+	var batch [][][][]float32
+	var indices []int
+	for i, d := range decoded {
+		if d.err != nil {
+			response.Results[i] = BatchResult{Source: d.source, Error: d.err.Error()}
+			continue
+		}
+		image := d.tensor.Value().([][][][]float32)[0]
+		batch = append(batch, image)
+		indices = append(indices, i)
+	}
+
+	if len(batch) == 0 {
+		return response
+	}
+
+	tensor, err := tf.NewTensor(batch)
+	if err != nil {
+		for _, i := range indices {
+			response.Results[i] = BatchResult{Source: decoded[i].source, Error: err.Error()}
+		}
+		return response
+	}
+
+	predictions, err := runInference(tensor)
+	if err != nil {
+		for _, i := range indices {
+			response.Results[i] = BatchResult{Source: decoded[i].source, Error: err.Error()}
+		}
+		return response
+	}
+
+	for n, i := range indices {
+		response.Results[i] = BatchResult{
+			Source:    decoded[i].source,
+			Results:   getTopK(predictions[n], 5),
+			LatencyMS: decoded[i].took.Seconds() * 1000,
+		}
+	}
+
+	return response
+}
+
+// runInference runs one forward pass through the shared tf.Session.
+// Session.Run is safe to call concurrently from multiple goroutines (the
+// same guarantee preprocessor.run and nsfw.Classifier.Classify already
+// rely on for their own dedicated sessions), so /recognize and
+// /recognize/batch can both call this without serializing on a lock.
+func runInference(tensor *tf.Tensor) ([][]float32, error) {
+	output, err := session.Run(
+		map[tf.Output]*tf.Tensor{
+			graph.Operation(currentModel.InputOp()).Output(0): tensor,
+		},
+		[]tf.Output{
+			graph.Operation(currentModel.OutputOp()).Output(0),
+		},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return output[0].Value().([][]float32), nil
+}
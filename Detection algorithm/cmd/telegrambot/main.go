@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// apiBaseURL is the Telegram Bot API endpoint, templated with the bot token.
+const apiBaseURL = "https://api.telegram.org/bot%s/%s"
+
+// update is the subset of Telegram's Update object this bot cares about.
+type update struct {
+	UpdateID int     `json:"update_id"`
+	Message  message `json:"message"`
+}
+
+type message struct {
+	Chat  chat        `json:"chat"`
+	Photo []photoSize `json:"photo"`
+}
+
+type chat struct {
+	ID int64 `json:"id"`
+}
+
+type photoSize struct {
+	FileID string `json:"file_id"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type getUpdatesResponse struct {
+	OK     bool     `json:"ok"`
+	Result []update `json:"result"`
+}
+
+type getFileResponse struct {
+	OK     bool `json:"ok"`
+	Result struct {
+		FilePath string `json:"file_path"`
+	} `json:"result"`
+}
+
+// recognitionResult mirrors the JSON shape returned by the /recognize
+// endpoint; it's redeclared here rather than imported since this binary
+// has no dependency on the recognizer's package.
+type recognitionResult struct {
+	Label      string   `json:"label"`
+	Confidence float32  `json:"confidence"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// recognizeResponse mirrors /recognize's response envelope: "labels" is
+// always present regardless of whether the recognizer has NSFW filtering
+// enabled, so this bot never needs to branch on that flag.
+type recognizeResponse struct {
+	Labels []recognitionResult `json:"labels"`
+}
+
+// bot is a long-polling Telegram Bot API client that forwards photos to a
+// recognizer endpoint and replies with the resulting labels.
+type bot struct {
+	token        string
+	recognizeURL string
+	threshold    float32
+	client       *http.Client
+}
+
+func main() {
+	token := flag.String("token", "", "Telegram bot token issued by @BotFather")
+	recognizeURL := flag.String("recognize-url", "http://localhost:8080/recognize", "URL of the recognizer's /recognize endpoint")
+	threshold := flag.Float64("threshold", 0, "suppress labels below this confidence in the reply")
+	flag.Parse()
+
+	if *token == "" {
+		log.Fatal("Missing required -token flag")
+	}
+
+	b := &bot{
+		token:        *token,
+		recognizeURL: *recognizeURL,
+		threshold:    float32(*threshold),
+		client:       &http.Client{Timeout: 60 * time.Second},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	log.Println("Telegram bot started, polling for updates")
+	b.run(ctx)
+}
+
+// run long-polls getUpdates until ctx is canceled.
+func (b *bot) run(ctx context.Context) {
+	offset := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		updates, err := b.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("getUpdates failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if len(u.Message.Photo) == 0 {
+				continue
+			}
+			if err := b.handlePhoto(u.Message.Chat.ID, u.Message.Photo); err != nil {
+				log.Printf("failed to handle photo from chat %d: %v", u.Message.Chat.ID, err)
+			}
+		}
+	}
+}
+
+// getUpdates polls Telegram for new updates, waiting up to 30 seconds for
+// one to arrive.
+func (b *bot) getUpdates(ctx context.Context, offset int) ([]update, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.apiURL("getUpdates", url.Values{
+		"offset":  {fmt.Sprintf("%d", offset)},
+		"timeout": {"30"},
+	}), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed getUpdatesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+	if !parsed.OK {
+		return nil, fmt.Errorf("getUpdates returned ok=false")
+	}
+
+	return parsed.Result, nil
+}
+
+// handlePhoto downloads the largest PhotoSize, sends it to the recognizer,
+// and replies to the chat with the formatted top-K labels.
+func (b *bot) handlePhoto(chatID int64, sizes []photoSize) error {
+	largest := sizes[0]
+	for _, s := range sizes {
+		if s.Width*s.Height > largest.Width*largest.Height {
+			largest = s
+		}
+	}
+
+	data, err := b.downloadFile(largest.FileID)
+	if err != nil {
+		return fmt.Errorf("downloading photo: %w", err)
+	}
+
+	results, err := b.recognize(data)
+	if err != nil {
+		return fmt.Errorf("calling recognizer: %w", err)
+	}
+
+	return b.sendMessage(chatID, formatResults(results, b.threshold))
+}
+
+// downloadFile resolves a Telegram file_id to its file_path via getFile
+// and downloads the file's bytes.
+func (b *bot) downloadFile(fileID string) ([]byte, error) {
+	resp, err := b.client.Get(b.apiURL("getFile", url.Values{"file_id": {fileID}}))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed getFileResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+	if !parsed.OK {
+		return nil, fmt.Errorf("getFile returned ok=false")
+	}
+
+	fileURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", b.token, parsed.Result.FilePath)
+	fileResp, err := b.client.Get(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer fileResp.Body.Close()
+
+	return ioutil.ReadAll(fileResp.Body)
+}
+
+// recognize POSTs the image bytes to the recognizer's /recognize endpoint.
+func (b *bot) recognize(data []byte) ([]recognitionResult, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("image", "photo.jpg")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Post(b.recognizeURL, writer.FormDataContentType(), &body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("recognizer returned %s: %s", resp.Status, respBody)
+	}
+
+	var decoded recognizeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	return decoded.Labels, nil
+}
+
+// sendMessage replies to a chat with a plain text message.
+func (b *bot) sendMessage(chatID int64, text string) error {
+	resp, err := b.client.PostForm(b.apiURL("sendMessage", nil), url.Values{
+		"chat_id": {fmt.Sprintf("%d", chatID)},
+		"text":    {text},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// apiURL builds a Telegram Bot API URL for the given method, with optional
+// query parameters merged in.
+func (b *bot) apiURL(method string, params url.Values) string {
+	base := fmt.Sprintf(apiBaseURL, b.token, method)
+	if len(params) == 0 {
+		return base
+	}
+	return base + "?" + params.Encode()
+}
+
+// formatResults renders recognition results as a reply message, dropping
+// anything below threshold.
+func formatResults(results []recognitionResult, threshold float32) string {
+	var lines []string
+	for _, r := range results {
+		if r.Confidence < threshold {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s (%.1f%%)", r.Label, r.Confidence*100))
+	}
+	if len(lines) == 0 {
+		return "I couldn't confidently recognize anything in that photo."
+	}
+	return strings.Join(lines, "\n")
+}
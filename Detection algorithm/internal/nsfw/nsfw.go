@@ -0,0 +1,131 @@
+// Package nsfw wraps a second TensorFlow classifier dedicated to flagging
+// unsafe images, independent of the main label classifier so the two can
+// be served from different devices.
+package nsfw
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	tf "github.com/tensorflow/tensorflow/tensorflow/go"
+	"gopkg.in/yaml.v2"
+)
+
+// Categories are the five classes produced by the classifier, in the
+// order its output tensor emits them (the layout used by GantMan's
+// nsfw_model MobileNet classifier).
+var Categories = []string{"drawing", "hentai", "neutral", "porn", "sexy"}
+
+// Safety holds a per-category NSFW score for a single image.
+type Safety struct {
+	Drawing float32 `json:"drawing"`
+	Hentai  float32 `json:"hentai"`
+	Neutral float32 `json:"neutral"`
+	Porn    float32 `json:"porn"`
+	Sexy    float32 `json:"sexy"`
+}
+
+// Thresholds configures the per-category score above which an image is
+// considered unsafe. Neutral has no threshold since it is never the
+// reason an image gets flagged.
+type Thresholds struct {
+	Drawing float32 `yaml:"drawing"`
+	Hentai  float32 `yaml:"hentai"`
+	Porn    float32 `yaml:"porn"`
+	Sexy    float32 `yaml:"sexy"`
+}
+
+// DefaultThresholds mirror the values commonly used with GantMan's
+// nsfw_model MobileNet classifier.
+var DefaultThresholds = Thresholds{
+	Drawing: 0.8,
+	Hentai:  0.6,
+	Porn:    0.6,
+	Sexy:    0.7,
+}
+
+// LoadThresholds reads per-category thresholds from a YAML config file,
+// falling back to DefaultThresholds for any category it doesn't set.
+func LoadThresholds(path string) (Thresholds, error) {
+	thresholds := DefaultThresholds
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return thresholds, err
+	}
+	if err := yaml.Unmarshal(content, &thresholds); err != nil {
+		return thresholds, fmt.Errorf("failed to parse NSFW thresholds file %q: %w", path, err)
+	}
+
+	return thresholds, nil
+}
+
+// Unsafe reports whether s exceeds any of t's category thresholds.
+func (s Safety) Unsafe(t Thresholds) bool {
+	return s.Drawing > t.Drawing || s.Hentai > t.Hentai || s.Porn > t.Porn || s.Sexy > t.Sexy
+}
+
+// Classifier runs a frozen NSFW graph in its own session.
+type Classifier struct {
+	graph    *tf.Graph
+	session  *tf.Session
+	inputOp  string
+	outputOp string
+}
+
+// Load imports the frozen graph at path and wires up its input/output op
+// names for later Classify calls.
+func Load(path, inputOp, outputOp string) (*Classifier, error) {
+	graphData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NSFW model file %q: %w", path, err)
+	}
+
+	graph := tf.NewGraph()
+	if err := graph.Import(graphData, ""); err != nil {
+		return nil, fmt.Errorf("failed to import NSFW graph: %w", err)
+	}
+
+	session, err := tf.NewSession(graph, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NSFW session: %w", err)
+	}
+
+	return &Classifier{graph: graph, session: session, inputOp: inputOp, outputOp: outputOp}, nil
+}
+
+// Classify runs tensor (already decoded/resized/normalized by the shared
+// preprocessing pipeline) through the NSFW session and returns its
+// per-category scores.
+func (c *Classifier) Classify(tensor *tf.Tensor) (Safety, error) {
+	output, err := c.session.Run(
+		map[tf.Output]*tf.Tensor{
+			c.graph.Operation(c.inputOp).Output(0): tensor,
+		},
+		[]tf.Output{
+			c.graph.Operation(c.outputOp).Output(0),
+		},
+		nil,
+	)
+	if err != nil {
+		return Safety{}, err
+	}
+
+	scores := output[0].Value().([][]float32)[0]
+	if len(scores) != len(Categories) {
+		return Safety{}, fmt.Errorf("NSFW model returned %d scores, expected %d", len(scores), len(Categories))
+	}
+
+	return Safety{
+		Drawing: scores[0],
+		Hentai:  scores[1],
+		Neutral: scores[2],
+		Porn:    scores[3],
+		Sexy:    scores[4],
+	}, nil
+}
+
+// Close releases the classifier's session.
+func (c *Classifier) Close() error {
+	return c.session.Close()
+}
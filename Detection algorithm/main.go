@@ -1,32 +1,33 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/floorusiek/AI/internal/nsfw"
 	tf "github.com/tensorflow/tensorflow/tensorflow/go"
-	"github.com/nfnt/resize"
 )
 
 var (
-	model       *tf.SavedModel
-	labels      []string
-	graph       *tf.Graph
-	session     *tf.Session
-	inputHeight = 224
-	inputWidth  = 224
+	model        *tf.SavedModel
+	labels       []string
+	graph        *tf.Graph
+	session      *tf.Session
+	rules        RuleSet
+	currentModel Model
+	prep         *preprocessor
+	inputHeight  = 224
+	inputWidth   = 224
+
+	calibrationTemperature float32 = 1.0
 )
 
 func loadLabels(filename string) ([]string, error) {
@@ -45,123 +46,131 @@ func loadLabels(filename string) ([]string, error) {
 	return filtered, nil
 }
 
-func readImage(r io.Reader) (image.Image, error) {
-	img, err := jpeg.Decode(r)
-	if err == nil {
-		return img, nil
-	}
-	_, err = r.Seek(0, io.SeekStart) 
-	if seeker, ok := r.(io.Seeker); ok {
-		seeker.Seek(0, io.SeekStart)
-	}
-	img, err = png.Decode(r)
-	if err == nil {
-		return img, nil
-	}
-	return nil, fmt.Errorf("unsupported image format (only JPEG or PNG supported)")
-}
-
-func preprocessImage(img image.Image) (*tf.Tensor, error) {
-	resized := resize.Resize(uint(inputWidth), uint(inputHeight), img, resize.Lanczos3)
-
-	bounds := resized.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
-	var imgData []float32
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			r, g, b, _ := resized.At(x, y).RGBA()
-			imgData = append(imgData, float32(r)/65535.0)
-			imgData = append(imgData, float32(g)/65535.0)
-			imgData = append(imgData, float32(b)/65535.0)
-		}
-	}
-	tensor, err := tf.NewTensor([1]int{1})
-	if err != nil {
-		return nil, err
-	}
-	batch := make([][][][]float32, 1)
-	batch[0] = make([][][]float32, height)
-	idx := 0
-	for y := 0; y < height; y++ {
-		batch[0][y] = make([][]float32, width)
-		for x := 0; x < width; x++ {
-			p := make([]float32, 3)
-			copy(p, imgData[idx:idx+3])
-			idx += 3
-			batch[0][y][x] = p
-		}
-	}
+const defaultTopK = 5
 
-	tensor, err = tf.NewTensor(batch)
-	if err != nil {
-		return nil, err
+// prepareInput converts raw image bytes into the tensor fed to
+// currentModel's input op. Most models go through prep's shared
+// decode/resize/normalize graph, but a RawInput model's own graph
+// decodes and resizes internally, so it must receive the bytes
+// untouched instead.
+func prepareInput(data []byte) (*tf.Tensor, error) {
+	if currentModel.RawInput() {
+		return tf.NewTensor(string(data))
 	}
-
-	return tensor, nil
+	return prep.run(data)
 }
 
-func recognizeImage(tensor *tf.Tensor) ([]RecognitionResult, error) {
-	output, err := session.Run(
-		map[tf.Output]*tf.Tensor{
-			graph.Operation("input").Output(0): tensor,
-		},
-		[]tf.Output{
-			graph.Operation("MobilenetV1/Predictions/Reshape_1").Output(0),
-		},
-		nil,
-	)
+func recognizeImage(tensor *tf.Tensor, k int) ([]RecognitionResult, error) {
+	predictions, err := runInference(tensor)
 	if err != nil {
 		return nil, err
 	}
 
-	predictions := output[0].Value().([][]float32)[0]
-
-	results := getTopK(predictions, 5)
-
-	return results, nil
+	return getTopK(predictions[0], k), nil
 }
 
 type RecognitionResult struct {
-	Label      string  `json:"label"`
-	Confidence float32 `json:"confidence"`
+	Label      string   `json:"label"`
+	Confidence float32  `json:"confidence"`
+	Categories []string `json:"categories,omitempty"`
+	Priority   int      `json:"priority,omitempty"`
+}
+
+// RecognizeResponse is the stable JSON envelope returned by both
+// /recognize and /recognize/safe: Safety is only populated when
+// -nsfw-model is set, but the "labels" key is always present so callers
+// don't need to branch on server flags to decode a response.
+type RecognizeResponse struct {
+	Labels []RecognitionResult `json:"labels"`
+	Safety *nsfw.Safety        `json:"safety,omitempty"`
 }
 
 func getTopK(predictions []float32, k int) []RecognitionResult {
-	type pred struct {
-		index int
-		value float32
-	}
-	var preds []pred
-	for i, v := range predictions {
-		preds = append(preds, pred{i, v})
-	}
-	for i := 0; i < len(preds)-1; i++ {
-		for j := i + 1; j < len(preds); j++ {
-			if preds[j].value > preds[i].value {
-				preds[i], preds[j] = preds[j], preds[i]
-			}
-		}
+	if currentModel != nil && currentModel.Logits() {
+		predictions = softmax(predictions, calibrationTemperature)
 	}
-	if k > len(preds) {
-		k = len(preds)
+
+	if rules == nil {
+		return buildResults(topKIndices(predictions, k), k)
 	}
+
+	// A rule can drop one of the raw top-k candidates below its own
+	// threshold, so the raw top-k alone can under-fill the result set.
+	// Grow the candidate pool geometrically (k, 2k, 4k, ...) instead of
+	// jumping straight to a full scan, so the common case where most
+	// candidates survive their rule stays close to the O(n log k) heap
+	// selection this backfill sits on top of; only a request that rejects
+	// most of its top candidates pays for scanning the full ranking.
+	for candidates := k; ; candidates *= 2 {
+		if candidates >= len(predictions) {
+			candidates = len(predictions)
+		}
+
+		results := buildResults(topKIndices(predictions, candidates), k)
+		if len(results) >= k || candidates >= len(predictions) {
+			return results
+		}
+	}
+}
+
+// buildResults applies label lookup and rule filtering to preds, keeping
+// at most k survivors, then sorts them by rule priority and confidence.
+func buildResults(preds []pred, k int) []RecognitionResult {
 	var results []RecognitionResult
-	for i := 0; i < k; i++ {
-		idx := preds[i].index
+	for _, p := range preds {
+		if len(results) >= k {
+			break
+		}
+
 		label := "unknown"
-		if idx < len(labels) {
-			label = labels[idx]
+		if p.index < len(labels) {
+			label = labels[p.index]
+		}
+
+		if rules != nil {
+			result, keep := rules.apply(label, p.value)
+			if !keep {
+				continue
+			}
+			results = append(results, result)
+			continue
 		}
+
 		results = append(results, RecognitionResult{
 			Label:      label,
-			Confidence: preds[i].value,
+			Confidence: p.value,
 		})
 	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Priority != results[j].Priority {
+			return results[i].Priority > results[j].Priority
+		}
+		return results[i].Confidence > results[j].Confidence
+	})
+
 	return results
 }
 
+// topKFromQuery reads the ?top= query parameter, falling back to
+// defaultTopK and clamping to maxTopK so a caller can't force an
+// unbounded scan of the label set.
+func topKFromQuery(r *http.Request) int {
+	raw := r.URL.Query().Get("top")
+	if raw == "" {
+		return defaultTopK
+	}
+
+	k, err := strconv.Atoi(raw)
+	if err != nil || k <= 0 {
+		return defaultTopK
+	}
+	if k > maxTopK {
+		return maxTopK
+	}
+	return k
+}
+
 func recognizeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed, use POST", http.StatusMethodNotAllowed)
@@ -174,54 +183,121 @@ func recognizeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	img, err := readImage(file)
+	data, err := ioutil.ReadAll(file)
 	if err != nil {
-		http.Error(w, "Error decoding image: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Error reading image file: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	inputTensor, err := preprocessImage(img)
+	inputTensor, err := prepareInput(data)
 	if err != nil {
 		http.Error(w, "Error preprocessing image: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	results, err := recognizeImage(inputTensor)
+	results, err := recognizeImage(inputTensor, topKFromQuery(r))
 	if err != nil {
 		http.Error(w, "Error running inference: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	safety, err := classifySafety(data)
+	if err != nil {
+		http.Error(w, "Error running NSFW classifier: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	json.NewEncoder(w).Encode(RecognizeResponse{Labels: results, Safety: safety})
 }
 
 func main() {
-	modelPath := "mobilenet_v1_1.0_224_frozen.pb"
-	labelsPath := "labels.txt"
+	modelName := flag.String("model", "", "model backend to serve (mobilenet_v1, mobilenet_v2, inception_v3, inception_v5h, nasnet)")
+	modelPath := flag.String("model-path", "mobilenet_v1_1.0_224_frozen.pb", "path to the frozen graph or SavedModel directory")
+	labelsPath := flag.String("labels", "labels.txt", "path to the newline-separated labels file")
+	flag.IntVar(&batchMaxSize, "batch-max-size", batchMaxSize, "maximum number of images accepted per /recognize/batch request")
+	flag.IntVar(&batchConcurrency, "batch-concurrency", batchConcurrency, "number of images preprocessed concurrently within a batch")
+	temperature := flag.Float64("temperature", float64(calibrationTemperature), "softmax temperature used when calibrating logit-emitting models")
+	nsfwModelPath := flag.String("nsfw-model", "", "path to a frozen NSFW classifier graph; enables /recognize/safe when set")
+	nsfwInputOp := flag.String("nsfw-input-op", "input", "input op name of the NSFW graph")
+	nsfwOutputOp := flag.String("nsfw-output-op", "predictions/Softmax", "output op name of the NSFW graph")
+	nsfwThresholdsPath := flag.String("nsfw-thresholds", "nsfw_thresholds.yml", "path to a YAML file overriding the default NSFW category thresholds")
+	nsfwInputWidth := flag.Int("nsfw-input-width", 224, "input width expected by the NSFW graph")
+	nsfwInputHeight := flag.Int("nsfw-input-height", 224, "input height expected by the NSFW graph")
+	nsfwNormalization := flag.String("nsfw-normalization", "neg_one_one", "normalization expected by the NSFW graph (zero_one, neg_one_one, mean_std)")
+	flag.Parse()
+	calibrationTemperature = float32(*temperature)
 
-	graphData, err := ioutil.ReadFile(modelPath)
+	var err error
+	currentModel, err = lookupModel(*modelName)
 	if err != nil {
-		log.Fatalf("Failed to read model file %q: %v", modelPath, err)
+		log.Fatalf("Failed to select model: %v", err)
 	}
+	inputWidth, inputHeight = currentModel.InputSize()
 
-	graph = tf.NewGraph()
-	if err := graph.Import(graphData, ""); err != nil {
-		log.Fatalf("Failed to import graph: %v", err)
+	if err := loadBackend(currentModel, *modelPath); err != nil {
+		log.Fatalf("Failed to load model %q: %v", currentModel.Name(), err)
 	}
+	defer session.Close()
 
-	session, err = tf.NewSession(graph, nil)
-	if err != nil {
-		log.Fatalf("Failed to create TensorFlow session: %v", err)
+	if !currentModel.RawInput() {
+		prep, err = newPreprocessor(currentModel)
+		if err != nil {
+			log.Fatalf("Failed to build preprocessing graph: %v", err)
+		}
+		defer prep.close()
 	}
-	defer session.Close()
 
-	labels, err = loadLabels(labelsPath)
+	labels, err = loadLabels(*labelsPath)
 	if err != nil {
 		log.Fatalf("Failed to load labels: %v", err)
 	}
 
+	rulesPath := "rules.yml"
+	if rules, err = loadRules(rulesPath); err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalf("Failed to load label rules: %v", err)
+		}
+		log.Printf("No label rules file found at %q, returning raw labels", rulesPath)
+	}
+
+	if *nsfwModelPath != "" {
+		nsfwClassifier, err = nsfw.Load(*nsfwModelPath, *nsfwInputOp, *nsfwOutputOp)
+		if err != nil {
+			log.Fatalf("Failed to load NSFW model: %v", err)
+		}
+		defer nsfwClassifier.Close()
+
+		nsfwNorm, err := parseNormalization(*nsfwNormalization)
+		if err != nil {
+			log.Fatalf("Invalid -nsfw-normalization: %v", err)
+		}
+		nsfwPrep, err = newPreprocessor(spec{
+			width:         *nsfwInputWidth,
+			height:        *nsfwInputHeight,
+			normalization: nsfwNorm,
+			mean:          imagenetMean,
+			std:           imagenetStd,
+		})
+		if err != nil {
+			log.Fatalf("Failed to build NSFW preprocessing graph: %v", err)
+		}
+		defer nsfwPrep.close()
+
+		if thresholds, err := nsfw.LoadThresholds(*nsfwThresholdsPath); err != nil {
+			if !os.IsNotExist(err) {
+				log.Fatalf("Failed to load NSFW thresholds: %v", err)
+			}
+			log.Printf("No NSFW thresholds file found at %q, using defaults", *nsfwThresholdsPath)
+		} else {
+			nsfwThresholds = thresholds
+		}
+
+		http.HandleFunc("/recognize/safe", safeRecognizeHandler)
+	}
+
 	http.HandleFunc("/recognize", recognizeHandler)
+	http.HandleFunc("/recognize/batch", batchHandler)
 	fmt.Println("Server started at :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
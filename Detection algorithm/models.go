@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	tf "github.com/tensorflow/tensorflow/tensorflow/go"
+)
+
+// ModelFormat identifies how a model's weights are stored on disk.
+type ModelFormat int
+
+const (
+	FormatFrozenGraph ModelFormat = iota
+	FormatSavedModel
+)
+
+// Normalization identifies how decoded [0, 1] pixel values must be rescaled
+// before being fed to a model's input tensor.
+type Normalization int
+
+const (
+	NormalizeZeroOne  Normalization = iota // leave values in [0, 1]
+	NormalizeNegOneOne                     // rescale to [-1, 1]
+	NormalizeMeanStd                       // subtract per-channel mean, divide by per-channel std
+)
+
+// parseNormalization resolves a -*-normalization flag value (zero_one,
+// neg_one_one, mean_std) to its Normalization constant.
+func parseNormalization(name string) (Normalization, error) {
+	switch name {
+	case "zero_one":
+		return NormalizeZeroOne, nil
+	case "neg_one_one":
+		return NormalizeNegOneOne, nil
+	case "mean_std":
+		return NormalizeMeanStd, nil
+	default:
+		return 0, fmt.Errorf("unknown normalization %q", name)
+	}
+}
+
+// Model describes a TensorFlow image classification backend: where its
+// graph lives, the names of its input and output ops, the image size it
+// expects, and how pixel values must be normalized before being fed in.
+// Swapping models is then a matter of picking a different Model, never
+// recompiling the recognizer. The preprocessing graph built by
+// newPreprocessor consults Normalization/MeanStd to decide which ops to
+// append after decode and resize.
+type Model interface {
+	Name() string
+	Format() ModelFormat
+	SavedModelTags() []string
+	InputOp() string
+	OutputOp() string
+	InputSize() (width, height int)
+	Normalization() Normalization
+	MeanStd() (mean, std [3]float32)
+	// Logits reports whether this model's output op emits raw logits that
+	// need softmax renormalization, as opposed to the MobileNet/Inception
+	// graphs below which already apply softmax internally.
+	Logits() bool
+	// RawInput reports whether InputOp is itself a raw-bytes placeholder
+	// (e.g. the classify_image tutorial's "DecodeJpeg/contents") that does
+	// its own decode/resize/normalize internally. Such a model must be fed
+	// the untouched image bytes and bypass the shared preprocessor, which
+	// would otherwise hand it an already-decoded float tensor its input op
+	// can't accept.
+	RawInput() bool
+}
+
+// spec is a data-driven Model implementation; every supported
+// architecture below is just a different set of spec values rather than
+// its own type.
+type spec struct {
+	name           string
+	format         ModelFormat
+	savedModelTags []string
+	inputOp        string
+	outputOp       string
+	width          int
+	height         int
+	normalization  Normalization
+	mean           [3]float32
+	std            [3]float32
+	logits         bool
+	rawInput       bool
+}
+
+func (s spec) Name() string                  { return s.name }
+func (s spec) Format() ModelFormat            { return s.format }
+func (s spec) SavedModelTags() []string       { return s.savedModelTags }
+func (s spec) InputOp() string                { return s.inputOp }
+func (s spec) OutputOp() string               { return s.outputOp }
+func (s spec) InputSize() (width, height int) { return s.width, s.height }
+func (s spec) Normalization() Normalization   { return s.normalization }
+func (s spec) Logits() bool                   { return s.logits }
+func (s spec) RawInput() bool                 { return s.rawInput }
+func (s spec) MeanStd() (mean, std [3]float32) {
+	return s.mean, s.std
+}
+
+var imagenetMean = [3]float32{0.485, 0.456, 0.406}
+var imagenetStd = [3]float32{0.229, 0.224, 0.225}
+
+// NewMobileNetV1 returns the Model for the frozen MobileNet v1 1.0 224
+// graph used by the original /recognize handler.
+func NewMobileNetV1() Model {
+	return spec{
+		name:          "mobilenet_v1",
+		format:        FormatFrozenGraph,
+		inputOp:       "input",
+		outputOp:      "MobilenetV1/Predictions/Reshape_1",
+		width:         224,
+		height:        224,
+		normalization: NormalizeZeroOne,
+	}
+}
+
+// NewMobileNetV2 returns the Model for MobileNet v2, served as a
+// SavedModel bundle.
+func NewMobileNetV2() Model {
+	return spec{
+		name:           "mobilenet_v2",
+		format:         FormatSavedModel,
+		savedModelTags: []string{"serve"},
+		inputOp:        "input",
+		outputOp:       "MobilenetV2/Predictions/Reshape_1",
+		width:          224,
+		height:         224,
+		normalization:  NormalizeNegOneOne,
+	}
+}
+
+// NewInceptionV3 returns the Model for Inception v3.
+func NewInceptionV3() Model {
+	return spec{
+		name:          "inception_v3",
+		format:        FormatFrozenGraph,
+		inputOp:       "input",
+		outputOp:      "InceptionV3/Predictions/Reshape_1",
+		width:         299,
+		height:        299,
+		normalization: NormalizeNegOneOne,
+	}
+}
+
+// NewInceptionV5h returns the Model for the frozen "v5h" Inception graph
+// shipped with the classify_image tutorial. Its input op decodes and
+// resizes raw JPEG bytes internally, so RawInput is set and the shared
+// preprocessor is bypassed in favor of handing it the untouched bytes.
+func NewInceptionV5h() Model {
+	return spec{
+		name:          "inception_v5h",
+		format:        FormatFrozenGraph,
+		inputOp:       "DecodeJpeg/contents",
+		outputOp:      "softmax",
+		width:         299,
+		height:        299,
+		normalization: NormalizeMeanStd,
+		mean:          imagenetMean,
+		std:           imagenetStd,
+		rawInput:      true,
+	}
+}
+
+// NewNASNet returns the Model for NASNet-A mobile, served as a SavedModel
+// bundle tagged for photoprism's custom export.
+func NewNASNet() Model {
+	return spec{
+		name:           "nasnet",
+		format:         FormatSavedModel,
+		savedModelTags: []string{"photoprism"},
+		inputOp:        "input_1",
+		outputOp:       "predictions/Softmax",
+		width:          224,
+		height:         224,
+		normalization:  NormalizeNegOneOne,
+	}
+}
+
+// models is the registry consulted by the -model flag.
+var models = map[string]func() Model{
+	"mobilenet_v1":  NewMobileNetV1,
+	"mobilenet_v2":  NewMobileNetV2,
+	"inception_v3":  NewInceptionV3,
+	"inception_v5h": NewInceptionV5h,
+	"nasnet":        NewNASNet,
+}
+
+// lookupModel resolves a -model flag value to its Model, defaulting to
+// MobileNet v1 to preserve the original behavior when the flag is unset.
+func lookupModel(name string) (Model, error) {
+	if name == "" {
+		return NewMobileNetV1(), nil
+	}
+	ctor, ok := models[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown model %q", name)
+	}
+	return ctor(), nil
+}
+
+// loadBackend loads m's weights from path, populating the frozen-graph
+// globals or the SavedModel global depending on m.Format().
+func loadBackend(m Model, path string) error {
+	switch m.Format() {
+	case FormatSavedModel:
+		loaded, err := tf.LoadSavedModel(path, m.SavedModelTags(), nil)
+		if err != nil {
+			return fmt.Errorf("failed to load SavedModel %q: %w", path, err)
+		}
+		model = loaded
+		graph = loaded.Graph
+		session = loaded.Session
+		return nil
+	default:
+		graphData, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read model file %q: %w", path, err)
+		}
+
+		g := tf.NewGraph()
+		if err := g.Import(graphData, ""); err != nil {
+			return fmt.Errorf("failed to import graph: %w", err)
+		}
+
+		s, err := tf.NewSession(g, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create TensorFlow session: %w", err)
+		}
+
+		graph = g
+		session = s
+		return nil
+	}
+}
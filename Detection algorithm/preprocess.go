@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+
+	tf "github.com/tensorflow/tensorflow/tensorflow/go"
+	"github.com/tensorflow/tensorflow/tensorflow/go/op"
+)
+
+// preprocessor is a one-time decode → resize → normalize TensorFlow graph,
+// built for a specific Model's input size and normalization scheme and
+// cached in its own session. It replaces the per-pixel Go loop of the
+// original preprocessImage with a single session.Run that can run on
+// whatever device (CPU/GPU) the session is placed on.
+type preprocessor struct {
+	graph   *tf.Graph
+	session *tf.Session
+	input   tf.Output
+	output  tf.Output
+}
+
+// newPreprocessor builds the graph for m. DecodeImage auto-detects the
+// content's format (JPEG, PNG, GIF, BMP) from its header, so callers no
+// longer need to sniff or double-decode raw bytes themselves.
+func newPreprocessor(m Model) (*preprocessor, error) {
+	s := op.NewScope()
+	input := op.Placeholder(s.SubScope("input"), tf.String)
+
+	decoded := op.DecodeImage(s.SubScope("decode"), input, op.DecodeImageChannels(3))
+
+	width, height := m.InputSize()
+	batched := op.ExpandDims(s,
+		op.Cast(s, decoded.Image, tf.Float),
+		op.Const(s.SubScope("make_batch"), int32(0)),
+	)
+	resized := op.ResizeBilinear(s.SubScope("resize"), batched,
+		op.Const(s.SubScope("size"), []int32{int32(height), int32(width)}),
+	)
+
+	normalized, err := normalize(s.SubScope("normalize"), resized, m)
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := s.Finalize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize preprocessing graph: %w", err)
+	}
+
+	session, err := tf.NewSession(graph, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preprocessing session: %w", err)
+	}
+
+	return &preprocessor{graph: graph, session: session, input: input, output: normalized}, nil
+}
+
+// normalize appends the ops that rescale decoded [0, 255] pixel values
+// according to m's normalization scheme.
+func normalize(s *op.Scope, pixels tf.Output, m Model) (tf.Output, error) {
+	scaled := op.Div(s.SubScope("to_unit_range"), pixels, op.Const(s.SubScope("max_pixel"), float32(255)))
+
+	switch m.Normalization() {
+	case NormalizeZeroOne:
+		return scaled, nil
+	case NormalizeNegOneOne:
+		return op.Sub(s,
+			op.Mul(s.SubScope("scale"), scaled, op.Const(s.SubScope("two"), float32(2))),
+			op.Const(s.SubScope("one"), float32(1)),
+		), nil
+	case NormalizeMeanStd:
+		mean, std := m.MeanStd()
+		return op.Div(s,
+			op.Sub(s.SubScope("subtract_mean"), scaled, op.Const(s.SubScope("mean"), mean)),
+			op.Const(s.SubScope("std"), std),
+		), nil
+	default:
+		return tf.Output{}, fmt.Errorf("unknown normalization scheme %v", m.Normalization())
+	}
+}
+
+// run decodes and preprocesses the raw image bytes data, returning the
+// normalized [1][height][width][3] tensor ready for inference.
+func (p *preprocessor) run(data []byte) (*tf.Tensor, error) {
+	tensor, err := tf.NewTensor(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := p.session.Run(
+		map[tf.Output]*tf.Tensor{p.input: tensor},
+		[]tf.Output{p.output},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return output[0], nil
+}
+
+// close releases the preprocessing session's resources.
+func (p *preprocessor) close() error {
+	return p.session.Close()
+}
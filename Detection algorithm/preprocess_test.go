@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// sampleJPEG encodes a synthetic 640x480 image so benchmarks don't depend
+// on a fixture file being present on disk.
+func sampleJPEG(b *testing.B) []byte {
+	b.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x % 256), G: uint8(y % 256), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		b.Fatalf("failed to encode sample JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkPreprocessGraph(b *testing.B) {
+	prep, err := newPreprocessor(NewMobileNetV1())
+	if err != nil {
+		b.Fatalf("failed to build preprocessing graph: %v", err)
+	}
+	defer prep.close()
+
+	data := sampleJPEG(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prep.run(data); err != nil {
+			b.Fatalf("preprocess failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkPreprocessGraphBatchUpload(b *testing.B) {
+	prep, err := newPreprocessor(NewMobileNetV1())
+	if err != nil {
+		b.Fatalf("failed to build preprocessing graph: %v", err)
+	}
+	defer prep.close()
+
+	images := make([][]byte, 8)
+	for i := range images {
+		images[i] = sampleJPEG(b)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, data := range images {
+			if _, err := prep.run(data); err != nil {
+				b.Fatalf("preprocess failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule describes how a raw ImageNet label should be curated before it is
+// returned to the caller: the minimum confidence required to keep it, the
+// canonical synonym to rewrite it to, the categories it belongs to, and a
+// priority used to break ties against other labels that also pass threshold.
+type Rule struct {
+	Label      string   `yaml:"label"`
+	Threshold  float32  `yaml:"threshold"`
+	See        string   `yaml:"see"`
+	Categories []string `yaml:"categories"`
+	Priority   int      `yaml:"priority"`
+}
+
+// RuleSet maps a lowercase label name to its Rule.
+type RuleSet map[string]Rule
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// loadRules reads a YAML rules file (main loads rules.yml from the working
+// directory) and validates it,
+// panicking on malformed entries so misconfiguration is caught at startup
+// rather than silently corrupting results at request time.
+func loadRules(filename string) (RuleSet, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed rulesFile
+	if err := yaml.Unmarshal(content, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %q: %w", filename, err)
+	}
+
+	rules := make(RuleSet, len(parsed.Rules))
+	for _, r := range parsed.Rules {
+		rules[r.Label] = r
+	}
+
+	validateRules(rules)
+
+	return rules, nil
+}
+
+// validateRules panics if any rule key is not lowercase or if a rule's See
+// field references a label that isn't itself defined, mirroring the gen
+// validator used for photoprism's label rules.
+func validateRules(rules RuleSet) {
+	for key, rule := range rules {
+		if key != strings.ToLower(key) {
+			panic(fmt.Sprintf("rules: label key %q must be lowercase", key))
+		}
+		if rule.See != "" {
+			if _, ok := rules[strings.ToLower(rule.See)]; !ok {
+				panic(fmt.Sprintf("rules: label %q references unknown see target %q", key, rule.See))
+			}
+		}
+	}
+}
+
+// apply rewrites and filters a raw prediction label according to the
+// matching rule, if any. It reports whether the label survives the
+// threshold check, along with its (possibly rewritten) label, categories,
+// and tie-break priority.
+func (rs RuleSet) apply(label string, confidence float32) (result RecognitionResult, keep bool) {
+	rule, ok := rs[strings.ToLower(label)]
+	if !ok {
+		return RecognitionResult{Label: label, Confidence: confidence}, true
+	}
+
+	if confidence < rule.Threshold {
+		return RecognitionResult{}, false
+	}
+
+	resolved := rule.Label
+	if rule.See != "" {
+		resolved = rule.See
+	}
+
+	return RecognitionResult{
+		Label:      resolved,
+		Confidence: confidence,
+		Categories: rule.Categories,
+		Priority:   rule.Priority,
+	}, true
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestRuleSetApplyNoMatchPassesThrough(t *testing.T) {
+	rs := RuleSet{}
+
+	result, keep := rs.apply("tabby cat", 0.42)
+	if !keep {
+		t.Fatalf("apply() keep = false, want true for an unmatched label")
+	}
+	if result.Label != "tabby cat" || result.Confidence != 0.42 {
+		t.Fatalf("apply() = %+v, want label/confidence passed through unchanged", result)
+	}
+}
+
+func TestRuleSetApplyBelowThresholdIsDropped(t *testing.T) {
+	rs := RuleSet{"tabby cat": {Label: "tabby cat", Threshold: 0.5}}
+
+	if _, keep := rs.apply("Tabby Cat", 0.3); keep {
+		t.Fatalf("apply() keep = true, want false below the rule's threshold")
+	}
+}
+
+func TestRuleSetApplyRewritesToSeeTarget(t *testing.T) {
+	rs := RuleSet{
+		"tabby cat": {Label: "tabby cat", Threshold: 0.1, See: "cat", Categories: []string{"animal"}, Priority: 2},
+		"cat":       {Label: "cat"},
+	}
+
+	result, keep := rs.apply("tabby cat", 0.9)
+	if !keep {
+		t.Fatalf("apply() keep = false, want true above threshold")
+	}
+	if result.Label != "cat" {
+		t.Fatalf("apply() label = %q, want rewritten to the See target %q", result.Label, "cat")
+	}
+	if result.Priority != 2 || len(result.Categories) != 1 || result.Categories[0] != "animal" {
+		t.Fatalf("apply() = %+v, want priority/categories carried over from the rule", result)
+	}
+}
+
+func TestValidateRulesPanicsOnUppercaseKey(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("validateRules did not panic on an uppercase label key")
+		}
+	}()
+	validateRules(RuleSet{"Tabby Cat": {Label: "Tabby Cat"}})
+}
+
+func TestValidateRulesPanicsOnUnknownSeeTarget(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("validateRules did not panic on a see target with no matching rule")
+		}
+	}()
+	validateRules(RuleSet{"tabby cat": {Label: "tabby cat", See: "nonexistent"}})
+}
+
+func TestValidateRulesAcceptsWellFormedRules(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("validateRules panicked on well-formed rules: %v", r)
+		}
+	}()
+	validateRules(RuleSet{
+		"tabby cat": {Label: "tabby cat", See: "cat"},
+		"cat":       {Label: "cat"},
+	})
+}
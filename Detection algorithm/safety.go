@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/floorusiek/AI/internal/nsfw"
+)
+
+var (
+	nsfwClassifier *nsfw.Classifier
+	nsfwThresholds = nsfw.DefaultThresholds
+	// nsfwPrep is a preprocessing graph sized and normalized for the NSFW
+	// graph's own trained input, independent of currentModel's prep: the
+	// two graphs are rarely trained on the same input size/normalization,
+	// so the main classifier's tensor can't just be reused here.
+	nsfwPrep *preprocessor
+)
+
+// classifySafety preprocesses data through nsfwPrep and scores it with
+// nsfwClassifier. It returns (nil, nil) when NSFW filtering is disabled.
+func classifySafety(data []byte) (*nsfw.Safety, error) {
+	if nsfwClassifier == nil {
+		return nil, nil
+	}
+
+	tensor, err := nsfwPrep.run(data)
+	if err != nil {
+		return nil, fmt.Errorf("preprocessing for NSFW classifier: %w", err)
+	}
+
+	safety, err := nsfwClassifier.Classify(tensor)
+	if err != nil {
+		return nil, fmt.Errorf("running NSFW classifier: %w", err)
+	}
+
+	return &safety, nil
+}
+
+// safeRecognizeHandler scores the image for unsafe content and refuses to
+// return labels (451) if any category exceeds its threshold.
+func safeRecognizeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if nsfwClassifier == nil {
+		http.Error(w, "NSFW filtering is not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Error reading image file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Error reading image file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	safety, err := classifySafety(data)
+	if err != nil {
+		http.Error(w, "Error running NSFW classifier: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if safety.Unsafe(nsfwThresholds) {
+		http.Error(w, "Image was flagged as unsafe", http.StatusUnavailableForLegalReasons)
+		return
+	}
+
+	tensor, err := prepareInput(data)
+	if err != nil {
+		http.Error(w, "Error preprocessing image: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results, err := recognizeImage(tensor, topKFromQuery(r))
+	if err != nil {
+		http.Error(w, "Error running inference: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecognizeResponse{Labels: results, Safety: safety})
+}
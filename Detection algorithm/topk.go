@@ -0,0 +1,114 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+)
+
+const maxTopK = 50
+
+// pred pairs a prediction's index into the labels slice with its score.
+type pred struct {
+	index int
+	value float32
+}
+
+// predHeap is a min-heap of pred ordered by value, then by descending
+// index, so the lowest-ranked of the top-k candidates seen so far is always
+// at the root and can be evicted in O(log k) when a larger candidate
+// arrives.
+type predHeap []pred
+
+func (h predHeap) Len() int { return len(h) }
+func (h predHeap) Less(i, j int) bool {
+	if h[i].value != h[j].value {
+		return h[i].value < h[j].value
+	}
+	return h[i].index > h[j].index
+}
+func (h predHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *predHeap) Push(x interface{}) { *h = append(*h, x.(pred)) }
+func (h *predHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// topKIndices returns the k highest-scoring predictions, descending by
+// value with ties broken by ascending label index for stability. It runs
+// in O(n log k) using a size-k min-heap instead of sorting all n
+// predictions, which matters once n is in the thousands of ImageNet
+// classes.
+func topKIndices(predictions []float32, k int) []pred {
+	if k > len(predictions) {
+		k = len(predictions)
+	}
+	if k <= 0 {
+		return nil
+	}
+
+	h := make(predHeap, 0, k)
+	for i, v := range predictions {
+		if h.Len() < k {
+			heap.Push(&h, pred{index: i, value: v})
+			continue
+		}
+		if v > h[0].value {
+			heap.Pop(&h)
+			heap.Push(&h, pred{index: i, value: v})
+		}
+	}
+
+	results := make([]pred, h.Len())
+	copy(results, h)
+	sortPredsDescending(results)
+	return results
+}
+
+// sortPredsDescending sorts by value descending, breaking ties by
+// ascending index so results are deterministic regardless of heap order.
+func sortPredsDescending(preds []pred) {
+	for i := 1; i < len(preds); i++ {
+		for j := i; j > 0 && less(preds[j], preds[j-1]); j-- {
+			preds[j], preds[j-1] = preds[j-1], preds[j]
+		}
+	}
+}
+
+func less(a, b pred) bool {
+	if a.value != b.value {
+		return a.value > b.value
+	}
+	return a.index < b.index
+}
+
+// softmax renormalizes raw logits into a probability distribution, scaled
+// by temperature (temperature < 1 sharpens, > 1 flattens confidence).
+// Models whose frozen graph already applies softmax should not pass
+// through here; it exists for custom models that emit raw logits.
+func softmax(logits []float32, temperature float32) []float32 {
+	if temperature <= 0 {
+		temperature = 1
+	}
+
+	maxLogit := logits[0]
+	for _, v := range logits {
+		if v > maxLogit {
+			maxLogit = v
+		}
+	}
+
+	out := make([]float32, len(logits))
+	var sum float32
+	for i, v := range logits {
+		e := float32(math.Exp(float64((v - maxLogit) / temperature)))
+		out[i] = e
+		sum += e
+	}
+	for i := range out {
+		out[i] /= sum
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTopKIndicesOrdersByValueDescending(t *testing.T) {
+	preds := topKIndices([]float32{0.1, 0.9, 0.4, 0.2}, 3)
+
+	want := []pred{{index: 1, value: 0.9}, {index: 2, value: 0.4}, {index: 3, value: 0.2}}
+	if len(preds) != len(want) {
+		t.Fatalf("got %d results, want %d", len(preds), len(want))
+	}
+	for i, p := range preds {
+		if p != want[i] {
+			t.Errorf("preds[%d] = %+v, want %+v", i, p, want[i])
+		}
+	}
+}
+
+func TestTopKIndicesBreaksTiesByAscendingIndex(t *testing.T) {
+	preds := topKIndices([]float32{0.5, 0.5, 0.5}, 2)
+
+	want := []pred{{index: 0, value: 0.5}, {index: 1, value: 0.5}}
+	if len(preds) != len(want) || preds[0] != want[0] || preds[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", preds, want)
+	}
+}
+
+func TestTopKIndicesClampsKToLength(t *testing.T) {
+	preds := topKIndices([]float32{0.1, 0.2}, 5)
+	if len(preds) != 2 {
+		t.Fatalf("got %d results, want 2", len(preds))
+	}
+}
+
+func TestTopKIndicesNonPositiveK(t *testing.T) {
+	if preds := topKIndices([]float32{0.1, 0.2}, 0); preds != nil {
+		t.Fatalf("got %+v, want nil", preds)
+	}
+}
+
+func TestSoftmaxSumsToOne(t *testing.T) {
+	out := softmax([]float32{1, 2, 3}, 1)
+
+	var sum float32
+	for _, v := range out {
+		sum += v
+	}
+	if math.Abs(float64(sum-1)) > 1e-5 {
+		t.Fatalf("softmax output sums to %v, want 1", sum)
+	}
+}
+
+func TestSoftmaxPreservesOrdering(t *testing.T) {
+	out := softmax([]float32{1, 3, 2}, 1)
+	if !(out[1] > out[2] && out[2] > out[0]) {
+		t.Fatalf("softmax(%v) = %v, want rank order preserved", []float32{1, 3, 2}, out)
+	}
+}
+
+func TestSoftmaxTemperatureFlattensDistribution(t *testing.T) {
+	sharp := softmax([]float32{1, 2, 3}, 0.5)
+	flat := softmax([]float32{1, 2, 3}, 2)
+
+	if !(flat[2] < sharp[2]) {
+		t.Fatalf("high temperature should flatten the top class: sharp=%v flat=%v", sharp, flat)
+	}
+}
+
+func TestSoftmaxNonPositiveTemperatureDefaultsToOne(t *testing.T) {
+	defaulted := softmax([]float32{1, 2, 3}, 0)
+	baseline := softmax([]float32{1, 2, 3}, 1)
+
+	for i := range defaulted {
+		if math.Abs(float64(defaulted[i]-baseline[i])) > 1e-6 {
+			t.Fatalf("softmax with temperature=0 = %v, want same as temperature=1 (%v)", defaulted, baseline)
+		}
+	}
+}